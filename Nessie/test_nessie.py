@@ -0,0 +1,226 @@
+import gzip
+import json
+import os
+import tarfile
+import tempfile
+import unittest
+from pathlib import Path
+from unittest import mock
+
+import yaml
+
+import nessie
+
+
+class HelmValuesRedactionTest(unittest.TestCase):
+    def test_credential_keys_are_redacted(self):
+        for key in ("password", "adminPassword", "apiKey", "api_key", "secretKey", "accessKey", "s3SecretKey",
+                    "privateKey", "key", "token", "authToken", "credentials", "secret"):
+            with self.subTest(key=key):
+                self.assertTrue(nessie.HELM_VALUES_SECRET_KEY_PATTERN.match(key))
+
+    def test_secret_references_are_kept(self):
+        for key in ("existingSecret", "secretName", "tokenSecretName", "passwordSecretRef", "secretKeyRef",
+                    "image", "replicas"):
+            with self.subTest(key=key):
+                self.assertFalse(nessie.HELM_VALUES_SECRET_KEY_PATTERN.match(key))
+
+    def test_nested_values_are_redacted(self):
+        values = {"s3": {"accessKey": "AKIA", "secretKey": "s3cr3t", "existingSecret": "s3-creds"}}
+        redacted = nessie.redact_keys(values, nessie.HELM_VALUES_SECRET_KEY_PATTERN, "helm-values-credentials")
+        self.assertEqual(redacted, {"s3": {"accessKey": "[REDACTED]", "secretKey": "[REDACTED]", "existingSecret": "s3-creds"}})
+
+
+class SecretRedactionTest(unittest.TestCase):
+    def test_last_applied_configuration_is_redacted(self):
+        secret = {"kind": "Secret", "data": {"password": "czNjcjN0"},
+                  "metadata": {"name": "db", "annotations": {nessie.LAST_APPLIED_ANNOTATION: '{"data":{"password":"czNjcjN0"}}'}}}
+        redacted = nessie.redact_secret_data(secret, "full/app/core_secrets/db.yaml")
+        self.assertNotIn("czNjcjN0", str(redacted))
+        self.assertIn("czNjcjN0", str(secret))
+
+
+class ReadOnlyGuardTest(unittest.TestCase):
+    def setUp(self):
+        self.original = nessie.rest.RESTClientObject.request
+        self.sent = mock.Mock(return_value="response")
+        # A plain function, a Mock would answer the guard's already-installed check
+        nessie.rest.RESTClientObject.request = lambda client, *args, **kwargs: self.sent(*args, **kwargs)
+        nessie.install_read_only_guard()
+
+    def tearDown(self):
+        nessie.rest.RESTClientObject.request = self.original
+
+    def test_writes_are_blocked_before_sending(self):
+        for method in ("POST", "PUT", "PATCH", "DELETE", "post"):
+            with self.subTest(method=method), self.assertLogs(nessie.logger, "ERROR"):
+                with self.assertRaises(nessie.ApiException):
+                    nessie.rest.RESTClientObject.request(object(), method, "https://api:6443/api/v1/namespaces/default/pods")
+        self.sent.assert_not_called()
+
+    def test_reads_are_sent(self):
+        client = object()
+        for method in ("GET", "HEAD", "OPTIONS"):
+            with self.subTest(method=method):
+                self.assertEqual(nessie.rest.RESTClientObject.request(client, method, "https://api:6443/api/v1/pods"), "response")
+        self.assertEqual(self.sent.call_count, 3)
+
+
+class VersionParsingTest(unittest.TestCase):
+    def test_parse_kube_version(self):
+        cases = {
+            "v1.28.10+k3s1": (1, 28, 10, "k3s", 1),
+            "v1.30.3+rke2r1": (1, 30, 3, "rke2", 1),
+            "v1.31.0-rc.1+k3s2": (1, 31, 0, "k3s", 2),
+            "v1.29.4": (1, 29, 4, None, None),
+            "1.27.16-rc.0": (1, 27, 16, None, None),
+        }
+        for version, expected in cases.items():
+            with self.subTest(version=version):
+                self.assertEqual(nessie.parse_kube_version(version), expected)
+
+    def test_parse_kube_version_unparsable(self):
+        for version in ("", None, "latest", "v1.28", "k3s1"):
+            with self.subTest(version=version):
+                self.assertIsNone(nessie.parse_kube_version(version))
+
+    def test_version_tuple(self):
+        self.assertEqual(nessie.version_tuple("v1.28.10+k3s1"), (1, 28, 10))
+        self.assertEqual(nessie.version_tuple("1.30.3+rke2r1"), (1, 30, 3))
+        self.assertEqual(nessie.version_tuple("v1.31.0-rc.1"), (1, 31, 0))
+        self.assertEqual(nessie.version_tuple("latest"), ())
+        self.assertLess(nessie.version_tuple("v1.9.0"), nessie.version_tuple("v1.10.0"))
+
+
+class WriteArtifactTest(unittest.TestCase):
+    def setUp(self):
+        self.tmp = tempfile.TemporaryDirectory()
+        self.directory = Path(self.tmp.name)
+
+    def tearDown(self):
+        self.tmp.cleanup()
+
+    def test_content_round_trips(self):
+        cases = {"notes.txt": ("line one\nline two\n", "line one\nline two\n"),
+                 "data.json": ({"nodes": ["a", "b"]}, {"nodes": ["a", "b"]}),
+                 "data.yaml": ([{"name": "a"}], [{"name": "a"}])}
+        for name, (content, expected) in cases.items():
+            with self.subTest(name=name):
+                written = nessie.write_artifact(self.directory / "nested" / name, content)
+                self.assertEqual(written, self.directory / "nested" / name)
+                text = written.read_text()
+                loaded = json.loads(text) if name.endswith(".json") else yaml.safe_load(text) if name.endswith(".yaml") else text
+                self.assertEqual(loaded, expected)
+
+    def test_uncreatable_parent_raises(self):
+        (self.directory / "blocker").write_text("a file, not a directory")
+        with self.assertRaises(OSError):
+            nessie.write_artifact(self.directory / "blocker" / "notes.txt", "content")
+
+    def test_failed_write_leaves_nothing_behind(self):
+        def failing_open(path, mode="r"):
+            f = open(path, mode)
+
+            class PartialFile:
+                def __enter__(self):
+                    return self
+
+                def __exit__(self, *exc):
+                    f.close()
+
+                def write(self, text):
+                    f.write(text[:4])
+                    raise OSError(28, "No space left on device")
+            return PartialFile()
+
+        with mock.patch.object(nessie, "open", failing_open, create=True), self.assertRaises(OSError):
+            nessie.write_artifact(self.directory / "notes.txt", "content that does not fit")
+        self.assertEqual(list(self.directory.iterdir()), [])
+
+    def test_large_content_is_compressed(self):
+        content = "x" * 100
+        with mock.patch.object(nessie, "STAGING_COMPRESS_BYTES", len(content)):
+            written = nessie.write_artifact(self.directory / "big.log", content)
+        self.assertEqual(written, self.directory / "big.log.gz")
+        self.assertFalse((self.directory / "big.log").exists())
+        with gzip.open(written, "rt") as f:
+            self.assertEqual(f.read(), content)
+
+
+class ZipLogsTest(unittest.TestCase):
+    FILES = {
+        "summary.txt": b"summary\n",
+        "logs/kube-system/coredns.log": b"coredns started\n",
+        "logs/kube-system/nested/deeper/rke2.log": b"\x00binary\xff",
+        "node/cpuinfo.txt": b"",
+    }
+
+    def setUp(self):
+        self.tmp = tempfile.TemporaryDirectory()
+        self.collection_dir = os.path.join(self.tmp.name, "bundle")
+        for relative_path, content in self.FILES.items():
+            path = os.path.join(self.collection_dir, relative_path)
+            os.makedirs(os.path.dirname(path), exist_ok=True)
+            with open(path, "wb") as f:
+                f.write(content)
+        self.zip_dir = os.path.join(self.tmp.name, "out")
+        os.makedirs(self.zip_dir)
+
+    def tearDown(self):
+        self.tmp.cleanup()
+
+    def assert_archive(self, archive):
+        expected_dirs = {"bundle"} | {"/".join(["bundle"] + p.split("/")[:i]) for p in self.FILES for i in range(1, p.count("/") + 1)}
+        with tarfile.open(archive, "r:gz") as tar:
+            members = tar.getmembers()
+            self.assertEqual({m.name for m in members if m.isdir()}, expected_dirs)
+            files = {m.name: tar.extractfile(m).read() for m in members if m.isfile()}
+            self.assertEqual(len(members), len(expected_dirs) + len(files))
+        self.assertEqual(files, {f"bundle/{p}": content for p, content in self.FILES.items()})
+
+    def test_archive_holds_exactly_the_collected_files(self):
+        archive = nessie.zip_logs(self.collection_dir, self.zip_dir, "bundle")
+        self.assertEqual(archive, os.path.join(self.zip_dir, "bundle.tar.gz"))
+        self.assert_archive(archive)
+
+    def test_second_run_does_not_corrupt_either_archive(self):
+        first = nessie.zip_logs(self.collection_dir, self.zip_dir, "bundle")
+        second = nessie.zip_logs(self.collection_dir, self.zip_dir, "bundle")
+        self.assertNotEqual(first, second)
+        self.assert_archive(first)
+        self.assert_archive(second)
+        self.assertEqual(sorted(os.listdir(self.zip_dir)), ["bundle.tar.gz", "bundle_2.tar.gz"])
+
+    def test_failure_while_writing_leaves_nothing_behind(self):
+        original_add = tarfile.TarFile.add
+
+        def failing_add(tar, name, arcname=None, **kwargs):
+            original_add(tar, os.path.join(name, "summary.txt"), arcname=f"{arcname}/summary.txt")
+            raise OSError(28, "No space left on device")
+
+        with mock.patch.object(tarfile.TarFile, "add", failing_add), self.assertLogs(nessie.logger, "ERROR"):
+            self.assertIsNone(nessie.zip_logs(self.collection_dir, self.zip_dir, "bundle"))
+        self.assertEqual(os.listdir(self.zip_dir), [])
+
+
+class SplitArchiveTest(unittest.TestCase):
+    def test_parts_rejoin_to_the_original(self):
+        with tempfile.TemporaryDirectory() as directory:
+            archive = os.path.join(directory, "bundle.tar.gz")
+            original = os.urandom(10 * 1024 + 17)
+            with open(archive, "wb") as f:
+                f.write(original)
+            parts = nessie.split_archive(archive, 4096)
+            self.assertEqual([os.path.basename(p) for p in parts], ["bundle.tar.gz.001", "bundle.tar.gz.002", "bundle.tar.gz.003"])
+            self.assertFalse(os.path.exists(archive))
+            self.assertTrue(all(os.path.getsize(p) <= 4096 for p in parts))
+            rejoined = b""
+            for part in parts:
+                with open(part, "rb") as f:
+                    rejoined += f.read()
+            self.assertEqual(rejoined, original)
+            self.assertEqual(sorted(os.listdir(directory)), sorted(os.path.basename(p) for p in parts))
+
+
+if __name__ == "__main__":
+    unittest.main()